@@ -0,0 +1,68 @@
+package telegram
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+func TestAddCounter(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   []byte
+		n    int64
+		want []byte
+	}{
+		{"zero", []byte{0, 0, 0, 0}, 0, []byte{0, 0, 0, 0}},
+		{"no carry", []byte{0, 0, 0, 1}, 2, []byte{0, 0, 0, 3}},
+		{"single carry", []byte{0, 0, 0, 0xff}, 1, []byte{0, 0, 1, 0}},
+		{"carry all the way", []byte{0xff, 0xff, 0xff, 0xff}, 1, []byte{0, 0, 0, 0}},
+		{"multi-byte increment", []byte{0, 0, 0, 0}, 0x1_0203, []byte{0, 1, 2, 3}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := make([]byte, len(tc.in))
+			copy(b, tc.in)
+			addCounter(b, tc.n)
+			if !bytes.Equal(b, tc.want) {
+				t.Errorf("addCounter(%v, %d) = %v, want %v", tc.in, tc.n, b, tc.want)
+			}
+		})
+	}
+}
+
+// TestCtrStreamAtMatchesFullStream checks that seeking a CTR keystream to
+// byteOffset and XOR-ing from there produces the same bytes as XOR-ing the
+// whole thing from the start and slicing, for a range of offsets that cross
+// several block boundaries.
+func TestCtrStreamAtMatchesFullStream(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := bytes.Repeat([]byte{0x07}, block.BlockSize())
+
+	plaintext := make([]byte, block.BlockSize()*4)
+	for i := range plaintext {
+		plaintext[i] = byte(i)
+	}
+
+	full := make([]byte, len(plaintext))
+	cipher.NewCTR(block, iv).XORKeyStream(full, plaintext)
+
+	for _, offset := range []int64{0, 1, block.BlockSize() - 1, block.BlockSize(), block.BlockSize() + 5, int64(len(plaintext)) - 1} {
+		stream, err := ctrStreamAt(block, iv, offset)
+		if err != nil {
+			t.Fatalf("ctrStreamAt(%d): %v", offset, err)
+		}
+
+		got := make([]byte, int64(len(plaintext))-offset)
+		stream.XORKeyStream(got, plaintext[offset:])
+
+		want := full[offset:]
+		if !bytes.Equal(got, want) {
+			t.Errorf("ctrStreamAt(%d) produced mismatched keystream", offset)
+		}
+	}
+}
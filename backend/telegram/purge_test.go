@@ -0,0 +1,131 @@
+package telegram
+
+import (
+	"sort"
+	"sync"
+	"testing"
+)
+
+func partsOf(ids ...int) []filePart {
+	parts := make([]filePart, len(ids))
+	for i, id := range ids {
+		parts[i] = filePart{MessageID: id}
+	}
+	return parts
+}
+
+func TestSameParts(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		a, b []filePart
+		want bool
+	}{
+		{"identical", partsOf(1, 2), partsOf(1, 2), true},
+		{"different length", partsOf(1, 2), partsOf(1, 2, 3), false},
+		{"different message ids", partsOf(1, 2), partsOf(1, 3), false},
+		{"both empty", nil, nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sameParts(tc.a, tc.b); got != tc.want {
+				t.Errorf("sameParts(%v, %v) = %v, want %v", tc.a, tc.b, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPlanPurgeSoleOwnerDeletes(t *testing.T) {
+	toRemove := map[string]fileIndexFile{
+		"a.txt": {Parts: partsOf(1), RefCount: 0},
+	}
+
+	actions := planPurge(toRemove)
+	if len(actions) != 1 {
+		t.Fatalf("got %d actions, want 1", len(actions))
+	}
+	if !actions[0].delete {
+		t.Errorf("sole owner should be deleted, got retarget to %d", actions[0].retargetTo)
+	}
+}
+
+func TestPlanPurgeSharedPartsSurviveWhenOnlyOneCopyPurged(t *testing.T) {
+	shared := partsOf(1, 2)
+	// RefCount 1 means 2 total owners; purging just one of them should
+	// leave the other with RefCount 0, not delete the underlying parts.
+	toRemove := map[string]fileIndexFile{
+		"a.txt": {Parts: shared, RefCount: 1},
+	}
+
+	actions := planPurge(toRemove)
+	if len(actions) != 1 {
+		t.Fatalf("got %d actions, want 1", len(actions))
+	}
+	if actions[0].delete {
+		t.Fatalf("shared parts with a surviving owner should not be deleted")
+	}
+	if actions[0].retargetTo != 0 {
+		t.Errorf("retargetTo = %d, want 0", actions[0].retargetTo)
+	}
+}
+
+func TestPlanPurgeBothCopiesTogetherDeletesOnce(t *testing.T) {
+	shared := partsOf(1, 2)
+	// Both owners purged in the same batch: combined effect should delete
+	// the parts exactly once, not twice, and not leave them dangling.
+	toRemove := map[string]fileIndexFile{
+		"a.txt": {Parts: shared, RefCount: 1},
+		"b.txt": {Parts: shared, RefCount: 1},
+	}
+
+	actions := planPurge(toRemove)
+	if len(actions) != 1 {
+		t.Fatalf("got %d actions for one shared-parts group, want 1: %+v", len(actions), actions)
+	}
+	if !actions[0].delete {
+		t.Errorf("purging every owner together should delete the parts, got retarget to %d", actions[0].retargetTo)
+	}
+}
+
+func TestPlanPurgeDistinctGroupsHandledIndependently(t *testing.T) {
+	toRemove := map[string]fileIndexFile{
+		"a.txt": {Parts: partsOf(1), RefCount: 0},
+		"b.txt": {Parts: partsOf(2, 3), RefCount: 2},
+	}
+
+	actions := planPurge(toRemove)
+	if len(actions) != 2 {
+		t.Fatalf("got %d actions, want 2: %+v", len(actions), actions)
+	}
+
+	sort.Slice(actions, func(i, j int) bool { return len(actions[i].parts) < len(actions[j].parts) })
+
+	if !actions[0].delete {
+		t.Errorf("a.txt's group should be deleted")
+	}
+	if actions[1].delete || actions[1].retargetTo != 1 {
+		t.Errorf("b.txt's group should retarget to 1, got %+v", actions[1])
+	}
+}
+
+func TestRetargetSyncsRefCountAcrossSharedEntries(t *testing.T) {
+	shared := partsOf(1, 2)
+	fi := &fileIndex{
+		Files: map[string]fileIndexFile{
+			"a.txt": {Parts: shared, RefCount: 1},
+			"b.txt": {Parts: shared, RefCount: 1},
+			"c.txt": {Parts: partsOf(3), RefCount: 0},
+		},
+		mutex: &sync.Mutex{},
+	}
+
+	fi.retarget(shared, 0)
+
+	if got := fi.Files["a.txt"].RefCount; got != 0 {
+		t.Errorf("a.txt RefCount = %d, want 0", got)
+	}
+	if got := fi.Files["b.txt"].RefCount; got != 0 {
+		t.Errorf("b.txt RefCount = %d, want 0", got)
+	}
+	if got := fi.Files["c.txt"].RefCount; got != 0 {
+		t.Errorf("unrelated entry c.txt RefCount changed to %d", got)
+	}
+}
@@ -0,0 +1,75 @@
+package telegram
+
+import "testing"
+
+func TestPartsDownloadJobs(t *testing.T) {
+	parts := []filePart{
+		{FileID: "a", Size: 10},
+		{FileID: "b", Size: 10},
+		{FileID: "c", Size: 10},
+	}
+
+	for _, tc := range []struct {
+		name       string
+		start, end int64
+		want       []downloadJob
+	}{
+		{
+			name:  "whole object",
+			start: 0, end: -1,
+			want: []downloadJob{
+				{fileID: "a", rangeStart: 0, rangeEnd: 9},
+				{fileID: "b", rangeStart: 0, rangeEnd: 9},
+				{fileID: "c", rangeStart: 0, rangeEnd: 9},
+			},
+		},
+		{
+			name:  "range within a single part",
+			start: 2, end: 5,
+			want: []downloadJob{
+				{fileID: "a", rangeStart: 2, rangeEnd: 4},
+			},
+		},
+		{
+			name:  "range spanning a part boundary",
+			start: 8, end: 12,
+			want: []downloadJob{
+				{fileID: "a", rangeStart: 8, rangeEnd: 9},
+				{fileID: "b", rangeStart: 0, rangeEnd: 1},
+			},
+		},
+		{
+			name:  "range starting exactly on a part boundary",
+			start: 10, end: 20,
+			want: []downloadJob{
+				{fileID: "b", rangeStart: 0, rangeEnd: 9},
+			},
+		},
+		{
+			name:  "open-ended range starting partway through the last part",
+			start: 25, end: -1,
+			want: []downloadJob{
+				{fileID: "c", rangeStart: 5, rangeEnd: 9},
+			},
+		},
+		{
+			name:  "end exactly on a part boundary excludes the next part",
+			start: 0, end: 10,
+			want: []downloadJob{
+				{fileID: "a", rangeStart: 0, rangeEnd: 9},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := partsDownloadJobs(parts, tc.start, tc.end)
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d jobs, want %d: %+v", len(got), len(tc.want), got)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("job %d = %+v, want %+v", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
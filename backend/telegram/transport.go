@@ -0,0 +1,515 @@
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/gotd/td/session"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+)
+
+// mtprotoMaxGetFileBytes is the largest chunk upload.getFile hands back in a
+// single call.
+const mtprotoMaxGetFileBytes = 1024 * 1024
+
+// transport is the wire protocol used to move chunk bytes in and out of the
+// channel, so Fs.Put, Object.Open, Object.Update and Object.Remove don't
+// need to care whether they're talking to the Bot API or MTProto directly.
+type transport interface {
+	// SendDocument uploads data as a new document message, returning its
+	// message ID and an opaque fileID that DownloadRange can later use to
+	// fetch it back.
+	SendDocument(ctx context.Context, name string, data []byte) (messageID int, fileID string, err error)
+	// EditDocument replaces the document attached to messageID, returning
+	// its new opaque fileID (file references can rotate on edit) and the
+	// message's updated edit_date.
+	EditDocument(ctx context.Context, messageID int, name string, data []byte) (fileID string, editDate int, err error)
+	// DeleteMessage deletes messageID.
+	DeleteMessage(ctx context.Context, messageID int) error
+	// DownloadRange fetches n bytes of fileID starting at byte off.
+	DownloadRange(ctx context.Context, fileID string, off, n int64) (io.ReadCloser, error)
+}
+
+// botTransport implements transport over the Bot API: uploads fan out across
+// pool, edits and deletes go through the primary (admin) bot, while downloads
+// go through Telegram's short-lived direct URLs (cached, since they're valid
+// for about an hour). It's capped by the Bot API's 50 MB upload / 20 MB
+// getFile limits.
+type botTransport struct {
+	channelID int64
+	pool      *botPool
+	primary   *tgbotapi.BotAPI
+	cache     Cacher
+}
+
+func (t *botTransport) SendDocument(ctx context.Context, name string, data []byte) (int, string, error) {
+	msg, err := t.pool.get().Send(tgbotapi.NewDocument(t.channelID, tgbotapi.FileBytes{Name: name, Bytes: data}))
+	if err != nil {
+		return 0, "", err
+	}
+	return msg.MessageID, msg.Document.FileID, nil
+}
+
+func (t *botTransport) EditDocument(ctx context.Context, messageID int, name string, data []byte) (string, int, error) {
+	msg, err := t.primary.Send(tgbotapi.EditMessageMediaConfig{
+		BaseEdit: tgbotapi.BaseEdit{
+			ChatID:    t.channelID,
+			MessageID: messageID,
+		},
+		Media: tgbotapi.NewInputMediaDocument(tgbotapi.FileBytes{Name: name, Bytes: data}),
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return msg.Document.FileID, msg.EditDate, nil
+}
+
+func (t *botTransport) DeleteMessage(ctx context.Context, messageID int) error {
+	_, err := t.primary.Request(tgbotapi.DeleteMessageConfig{
+		ChatID:    t.channelID,
+		MessageID: messageID,
+	})
+	return err
+}
+
+func (t *botTransport) DownloadRange(ctx context.Context, fileID string, off, n int64) (io.ReadCloser, error) {
+	url, err := t.cachedDirectURL(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "Open failed")
+	}
+	return res.Body, nil
+}
+
+// cachedDirectURL returns fileID's direct download URL, caching it for
+// directURLCacheTTL since Telegram's direct URLs are valid for about an
+// hour and a chunked object's parts are often re-read (e.g. by ranged
+// reads from the same object).
+func (t *botTransport) cachedDirectURL(ctx context.Context, fileID string) (string, error) {
+	key := "url:" + fileID
+
+	if cached, ok := t.cache.Get(ctx, key); ok {
+		return string(cached), nil
+	}
+
+	url, err := t.pool.get().GetFileDirectURL(fileID)
+	if err != nil {
+		return "", err
+	}
+
+	t.cache.Set(ctx, key, []byte(url), directURLCacheTTL)
+	return url, nil
+}
+
+// mtprotoFileRef is what ends up stashed in filePart.FileID under MTProto
+// mode. Unlike the Bot API's short-lived opaque file_id, upload.getFile
+// needs the document's ID, access hash and file reference to locate it, so
+// we encode all three and carry them around as our "file ID" instead.
+type mtprotoFileRef struct {
+	ID            int64  `json:"id"`
+	AccessHash    int64  `json:"access_hash"`
+	FileReference []byte `json:"file_reference"`
+}
+
+func (r mtprotoFileRef) location() *tg.InputDocumentFileLocation {
+	return &tg.InputDocumentFileLocation{
+		ID:            r.ID,
+		AccessHash:    r.AccessHash,
+		FileReference: r.FileReference,
+	}
+}
+
+func encodeFileRef(doc *tg.Document) (string, error) {
+	b, err := json.Marshal(mtprotoFileRef{
+		ID:            doc.ID,
+		AccessHash:    doc.AccessHash,
+		FileReference: doc.FileReference,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeFileRef(fileID string) (mtprotoFileRef, error) {
+	var ref mtprotoFileRef
+	b, err := base64.RawURLEncoding.DecodeString(fileID)
+	if err != nil {
+		return ref, err
+	}
+	err = json.Unmarshal(b, &ref)
+	return ref, err
+}
+
+// mtprotoTransport implements transport by logging into Telegram as a user
+// account via gotd/td and talking to the DC directly, bypassing the Bot
+// API's size limits and its lack of ranged downloads.
+type mtprotoTransport struct {
+	client       *telegram.Client
+	api          *tg.Client
+	channelID    int64 // as stored in config, Bot API style (-100…)
+	rawChannelID int64 // gotd/td's raw (positive) channel ID
+	accessHash   int64
+}
+
+// newMTProtoTransport logs in using the session persisted at
+// opt.SessionFile (generated out-of-band with gotd/td's auth flow; this
+// backend never prompts for a login code itself) and resolves the channel's
+// access hash, keeping the client connection open for the life of the Fs.
+func newMTProtoTransport(ctx context.Context, opt Options) (*mtprotoTransport, error) {
+	if opt.APIID == 0 || opt.APIHash == "" {
+		return nil, errors.New("api_id and api_hash are required for mode = mtproto")
+	}
+	if opt.SessionFile == "" {
+		return nil, errors.New("session_file is required for mode = mtproto")
+	}
+
+	client := telegram.NewClient(opt.APIID, opt.APIHash, telegram.Options{
+		SessionStorage: &session.FileStorage{Path: opt.SessionFile},
+	})
+
+	t := &mtprotoTransport{channelID: opt.ChannelID}
+
+	ready := make(chan error, 1)
+	go func() {
+		// client.Run blocks for as long as the connection is kept open, so
+		// this goroutine (and the connection) outlives newMTProtoTransport,
+		// running for the lifetime of the Fs.
+		err := client.Run(context.Background(), func(runCtx context.Context) error {
+			status, err := client.Auth().Status(runCtx)
+			if err != nil {
+				ready <- err
+				return err
+			}
+			if !status.Authorized {
+				err := errors.New("mtproto session_file is not authorized; log in out-of-band with gotd/td's auth flow first")
+				ready <- err
+				return err
+			}
+
+			t.client = client
+			t.api = client.API()
+			if err := t.resolveChannel(runCtx); err != nil {
+				ready <- err
+				return err
+			}
+
+			ready <- nil
+			<-runCtx.Done()
+			return nil
+		})
+		if err != nil {
+			fs.Logf(nil, "mtproto transport for channel %d stopped: %v", opt.ChannelID, err)
+		}
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			return nil, errors.Wrap(err, "mtproto login failed")
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return t, nil
+}
+
+// resolveChannel finds channelID's raw ID and access hash among this
+// account's dialogs; every raw MTProto request against the channel (and the
+// documents inside it) needs the access hash, which the Bot API's -100…
+// chat ID doesn't carry.
+func (t *mtprotoTransport) resolveChannel(ctx context.Context) error {
+	dialogs, err := t.api.MessagesGetDialogs(ctx, &tg.MessagesGetDialogsRequest{
+		OffsetPeer: &tg.InputPeerEmpty{},
+		Limit:      100,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to list dialogs while resolving channel")
+	}
+
+	var chats []tg.ChatClass
+	switch d := dialogs.(type) {
+	case *tg.MessagesDialogs:
+		chats = d.Chats
+	case *tg.MessagesDialogsSlice:
+		chats = d.Chats
+	default:
+		return errors.New("unexpected response type listing dialogs")
+	}
+
+	for _, c := range chats {
+		channel, ok := c.(*tg.Channel)
+		if !ok {
+			continue
+		}
+		if botAPIChannelID(channel.ID) == t.channelID {
+			t.rawChannelID = channel.ID
+			t.accessHash = channel.AccessHash
+			return nil
+		}
+	}
+
+	return errors.Errorf("channel %d not found among this account's dialogs; open it at least once from this account first", t.channelID)
+}
+
+// botAPIChannelID converts gotd/td's raw (positive) channel ID to the
+// -100-prefixed chat ID the Bot API (and so this backend's channel_id
+// option) uses.
+func botAPIChannelID(rawID int64) int64 {
+	return -1000000000000 - rawID
+}
+
+func (t *mtprotoTransport) inputPeer() *tg.InputPeerChannel {
+	return &tg.InputPeerChannel{ChannelID: t.rawChannelID, AccessHash: t.accessHash}
+}
+
+func (t *mtprotoTransport) inputChannel() *tg.InputChannel {
+	return &tg.InputChannel{ChannelID: t.rawChannelID, AccessHash: t.accessHash}
+}
+
+func (t *mtprotoTransport) SendDocument(ctx context.Context, name string, data []byte) (int, string, error) {
+	file, err := uploader.NewUploader(t.api).FromBytes(ctx, name, data)
+	if err != nil {
+		return 0, "", errors.Wrap(err, "mtproto upload failed")
+	}
+
+	randomID, err := randomMessageID()
+	if err != nil {
+		return 0, "", err
+	}
+
+	updates, err := t.api.MessagesSendMedia(ctx, &tg.MessagesSendMediaRequest{
+		Peer:     t.inputPeer(),
+		RandomID: randomID,
+		Media: &tg.InputMediaUploadedDocument{
+			File:     file,
+			MimeType: "application/octet-stream",
+			Attributes: []tg.DocumentAttributeClass{
+				&tg.DocumentAttributeFilename{FileName: name},
+			},
+		},
+	})
+	if err != nil {
+		return 0, "", errors.Wrap(err, "mtproto send failed")
+	}
+
+	messageID, doc, err := extractSentDocument(updates)
+	if err != nil {
+		return 0, "", err
+	}
+
+	fileID, err := encodeFileRef(doc)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return messageID, fileID, nil
+}
+
+func (t *mtprotoTransport) EditDocument(ctx context.Context, messageID int, name string, data []byte) (string, int, error) {
+	file, err := uploader.NewUploader(t.api).FromBytes(ctx, name, data)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "mtproto upload failed")
+	}
+
+	updates, err := t.api.MessagesEditMessage(ctx, &tg.MessagesEditMessageRequest{
+		Peer: t.inputPeer(),
+		ID:   messageID,
+		Media: &tg.InputMediaUploadedDocument{
+			File:     file,
+			MimeType: "application/octet-stream",
+			Attributes: []tg.DocumentAttributeClass{
+				&tg.DocumentAttributeFilename{FileName: name},
+			},
+		},
+	})
+	if err != nil {
+		return "", 0, errors.Wrap(err, "mtproto edit failed")
+	}
+
+	editDate, doc, err := extractEditedDocument(updates)
+	if err != nil {
+		return "", 0, err
+	}
+
+	fileID, err := encodeFileRef(doc)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return fileID, editDate, nil
+}
+
+func (t *mtprotoTransport) DeleteMessage(ctx context.Context, messageID int) error {
+	_, err := t.api.ChannelsDeleteMessages(ctx, &tg.ChannelsDeleteMessagesRequest{
+		Channel: t.inputChannel(),
+		ID:      []int{messageID},
+	})
+	return err
+}
+
+func (t *mtprotoTransport) DownloadRange(ctx context.Context, fileID string, off, n int64) (io.ReadCloser, error) {
+	ref, err := decodeFileRef(fileID)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid mtproto file reference")
+	}
+
+	return &mtprotoRangeReader{
+		ctx:       ctx,
+		api:       t.api,
+		location:  ref.location(),
+		off:       off,
+		remaining: n,
+	}, nil
+}
+
+// mtprotoRangeReader streams a ranged download by lazily fetching successive
+// mtprotoMaxGetFileBytes windows via upload.getFile as Read consumes them,
+// rather than buffering the whole (possibly huge) requested range up front.
+type mtprotoRangeReader struct {
+	ctx       context.Context
+	api       *tg.Client
+	location  *tg.InputDocumentFileLocation
+	off       int64
+	remaining int64
+	buf       []byte // unread bytes from the most recent getFile call
+}
+
+func (r *mtprotoRangeReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.remaining <= 0 {
+			return 0, io.EOF
+		}
+
+		want := r.remaining
+		if want > mtprotoMaxGetFileBytes {
+			want = mtprotoMaxGetFileBytes
+		}
+
+		res, err := r.api.UploadGetFile(r.ctx, &tg.UploadGetFileRequest{
+			Location: r.location,
+			Offset:   r.off,
+			Limit:    int(want),
+		})
+		if err != nil {
+			return 0, errors.Wrap(err, "mtproto getFile failed")
+		}
+
+		file, ok := res.(*tg.UploadFile)
+		if !ok {
+			return 0, errors.New("unexpected response type from mtproto getFile")
+		}
+		if len(file.Bytes) == 0 {
+			r.remaining = 0
+			return 0, io.EOF
+		}
+
+		r.off += int64(len(file.Bytes))
+		r.remaining -= int64(len(file.Bytes))
+		r.buf = file.Bytes
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *mtprotoRangeReader) Close() error {
+	return nil
+}
+
+// extractSentDocument digs the new message ID and uploaded Document back out
+// of the Updates envelope messages.sendMedia returns.
+func extractSentDocument(updates tg.UpdatesClass) (int, *tg.Document, error) {
+	u, ok := updates.(*tg.Updates)
+	if !ok {
+		return 0, nil, errors.New("unexpected updates type from mtproto sendMedia")
+	}
+
+	for _, upd := range u.Updates {
+		withMessage, ok := upd.(interface{ GetMessage() tg.MessageClass })
+		if !ok {
+			continue
+		}
+		msg, ok := withMessage.GetMessage().(*tg.Message)
+		if !ok {
+			continue
+		}
+		media, ok := msg.Media.(*tg.MessageMediaDocument)
+		if !ok {
+			continue
+		}
+		doc, ok := media.Document.(*tg.Document)
+		if !ok {
+			continue
+		}
+		return msg.ID, doc, nil
+	}
+
+	return 0, nil, errors.New("sendMedia response didn't include the new document")
+}
+
+// extractEditedDocument digs the edited message's edit_date and replacement
+// Document back out of the Updates envelope messages.editMessage returns.
+// File references rotate on edit, so callers need the new Document to keep
+// downloading the right bytes.
+func extractEditedDocument(updates tg.UpdatesClass) (int, *tg.Document, error) {
+	u, ok := updates.(*tg.Updates)
+	if !ok {
+		return 0, nil, errors.New("unexpected updates type from mtproto editMessage")
+	}
+
+	for _, upd := range u.Updates {
+		withMessage, ok := upd.(interface{ GetMessage() tg.MessageClass })
+		if !ok {
+			continue
+		}
+		msg, ok := withMessage.GetMessage().(*tg.Message)
+		if !ok {
+			continue
+		}
+		media, ok := msg.Media.(*tg.MessageMediaDocument)
+		if !ok {
+			continue
+		}
+		doc, ok := media.Document.(*tg.Document)
+		if !ok {
+			continue
+		}
+		return msg.EditDate, doc, nil
+	}
+
+	return 0, nil, errors.New("editMessage response didn't include the edited document")
+}
+
+// randomMessageID generates the client-side random ID messages.sendMedia
+// requires to de-duplicate retried sends.
+func randomMessageID() (int64, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b[:])), nil
+}